@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Backend removes the background from an image and returns the cutout.
+// RemoveBgBackend talks to the paid remove.bg API; LocalBackend and
+// HTTPBackend let air-gapped or self-hosted users swap that out while
+// keeping the same CLI ergonomics.
+type Backend interface {
+	RemoveBackground(ctx context.Context, imageBytes []byte) ([]byte, error)
+}
+
+// selectBackend builds the Backend named by config.Backend.
+func selectBackend(config Config) (Backend, error) {
+	switch config.Backend {
+	case "", "remove_bg":
+		return &RemoveBgBackend{ApiKey: config.ApiKey, Format: config.Format, limiter: config.limiter, Retries: config.Retries}, nil
+	case "local":
+		if config.BackendBin == "" {
+			return nil, fmt.Errorf("-backend local requires -backend-bin <path to rembg/onnx wrapper>")
+		}
+		return &LocalBackend{BinaryPath: config.BackendBin}, nil
+	case "http":
+		if config.BackendURL == "" {
+			return nil, fmt.Errorf("-backend http requires -backend-url <endpoint>")
+		}
+		return &HTTPBackend{
+			URL:       config.BackendURL,
+			Header:    config.BackendHeader,
+			FieldName: config.BackendField,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend: %s (want remove_bg, local, or http)", config.Backend)
+	}
+}
+
+// RemoveBgBackend calls the remove.bg API, the same request the tool has
+// always made, now behind the Backend interface.
+type RemoveBgBackend struct {
+	ApiKey  string
+	Format  string
+	Retries int // additional attempts, with exponential backoff, on 429/5xx
+	limiter *rateLimiter
+
+	// LastRequestID is the X-Request-Id of the most recent response, surfaced
+	// so callers (see cutoutStage) can record it in the resume manifest.
+	LastRequestID string
+}
+
+func (b *RemoveBgBackend) RemoveBackground(ctx context.Context, imageBytes []byte) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= b.Retries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		outputData, retryable, err := b.attempt(ctx, imageBytes)
+		if err == nil {
+			return outputData, nil
+		}
+		lastErr = err
+		if !retryable {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("giving up after %d attempt(s): %w", b.Retries+1, lastErr)
+}
+
+// attempt makes one call to the remove.bg API. retryable reports whether the
+// failure is worth retrying with backoff: a 429/5xx response, a failed
+// request, or a failed read of the response body.
+func (b *RemoveBgBackend) attempt(ctx context.Context, imageBytes []byte) (data []byte, retryable bool, err error) {
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+
+	part, err := writer.CreateFormFile("image_file", "image")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, bytes.NewReader(imageBytes)); err != nil {
+		return nil, false, fmt.Errorf("failed to copy image data: %w", err)
+	}
+
+	if err := writer.WriteField("size", "full"); err != nil {
+		return nil, false, fmt.Errorf("failed to write field 'size': %w", err)
+	}
+
+	if b.Format == "webp" {
+		if err := writer.WriteField("format", "webp"); err != nil {
+			return nil, false, fmt.Errorf("failed to write field 'format': %w", err)
+		}
+	}
+
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, &requestBody)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("X-Api-Key", b.ApiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	if err := b.limiter.wait(ctx); err != nil {
+		return nil, false, fmt.Errorf("rate limiter: %w", err)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b.limiter.adjust(resp)
+	b.LastRequestID = resp.Header.Get("X-Request-Id")
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, true, fmt.Errorf("API error: %s - %s", resp.Status, string(bodyBytes))
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, false, fmt.Errorf("API error: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	outputData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return outputData, false, nil
+}
+
+// LocalBackend shells out to a user-configured binary (e.g. rembg, or an
+// ONNX runtime wrapper around a U^2-Net-style model) that follows the
+// `binary <input> <output>` convention to produce the alpha matte locally.
+type LocalBackend struct {
+	BinaryPath string
+}
+
+func (b *LocalBackend) RemoveBackground(ctx context.Context, imageBytes []byte) ([]byte, error) {
+	inputFile, err := os.CreateTemp("", "rmbg-in-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp input file: %w", err)
+	}
+	defer os.Remove(inputFile.Name())
+	defer inputFile.Close()
+
+	if _, err := inputFile.Write(imageBytes); err != nil {
+		return nil, fmt.Errorf("failed to write temp input file: %w", err)
+	}
+	inputFile.Close()
+
+	outputPath := inputFile.Name() + "-out.png"
+	defer os.Remove(outputPath)
+
+	cmd := exec.CommandContext(ctx, b.BinaryPath, inputFile.Name(), outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", b.BinaryPath, err, strings.TrimSpace(stderr.String()))
+	}
+
+	outputData, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s output: %w", b.BinaryPath, err)
+	}
+
+	return outputData, nil
+}
+
+// HTTPBackend posts to an arbitrary self-hosted background-removal endpoint.
+type HTTPBackend struct {
+	URL       string
+	Header    string // optional "Name: value" header, e.g. an auth token
+	FieldName string // multipart field name, defaults to "file"
+}
+
+func (b *HTTPBackend) RemoveBackground(ctx context.Context, imageBytes []byte) ([]byte, error) {
+	fieldName := b.FieldName
+	if fieldName == "" {
+		fieldName = "file"
+	}
+
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+
+	part, err := writer.CreateFormFile(fieldName, "image")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, bytes.NewReader(imageBytes)); err != nil {
+		return nil, fmt.Errorf("failed to copy image data: %w", err)
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", b.URL, &requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	if b.Header != "" {
+		name, value, ok := strings.Cut(b.Header, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid -backend-header %q, want \"Name: value\"", b.Header)
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("backend error: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	outputData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return outputData, nil
+}
@@ -1,41 +1,208 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/h2non/bimg"
+	"golang.org/x/time/rate"
 )
 
 const (
 	apiURL      = "https://api.remove.bg/v1.0/removebg"
 	version     = "1.0.0"
 	programName = "rmbg"
+
+	defaultJobs          = 4
+	defaultDiffThreshold = 25
+	defaultWebpQuality   = 80
 )
 
 // Config holds the application configuration
 type Config struct {
-	Format     string
-	Compress   bool
-	Quality    int
-	ApiKey     string
-	InputPath  string
-	OutputPath string
+	Format        string
+	Compress      bool
+	Quality       int
+	ApiKey        string
+	InputPath     string
+	OutputPath    string
+	Jobs          int
+	RateLimit     int    // requests per minute, 0 means unlimited
+	DryRun        bool
+	DiffThreshold int    // minimum % size reduction required to write the output in dry-run mode
+	Local         bool   // skip the remove.bg call; input is already a cutout
+	MaxWidth      int    // downscale outputs wider than this, 0 means no limit
+	MaxHeight     int    // downscale outputs taller than this, 0 means no limit
+	AtLeastKB     int    // skip resizing outputs smaller than this
+	AlsoWebp      bool   // also emit a <name>-rm.webp sidecar next to the primary output
+	WebpQuality   int    // quality used for the -also-webp sidecar
+
+	Backend       string // remove_bg (default), local, or http
+	BackendBin    string // binary path for -backend local
+	BackendURL    string // endpoint for -backend http
+	BackendHeader string // optional "Name: value" header for -backend http
+	BackendField  string // multipart field name for -backend http
+
+	Force   bool // ignore the resume manifest and reprocess every file
+	Retries int  // retries with exponential backoff on transient 429/5xx errors
+
+	limiter *rateLimiter
 }
 
 // ProcessResult represents the result of processing a single image
 type ProcessResult struct {
+	Filename   string
+	Success    bool
+	Error      error
+	Stats      ImageStats
+	Hash       string // content hash of the input file, for the resume manifest
+	HashError  error  // set if Hash could not be computed; independent of Success/Error
+	OutputPath string
+}
+
+// ImageStats records the before/after size of a processed image so callers
+// can report savings and decide whether the output was actually written.
+type ImageStats struct {
+	OriginalBytes int
+	FinalBytes    int
+	Written       bool
+	RequestID     string // remove.bg X-Request-Id, if the backend reported one
+}
+
+// reductionPercent returns how much smaller finalBytes is than originalBytes, as a percentage.
+func reductionPercent(originalBytes, finalBytes int) float64 {
+	if originalBytes <= 0 {
+		return 0
+	}
+	return float64(originalBytes-finalBytes) / float64(originalBytes) * 100
+}
+
+// savingsRow is one line of the -dry-run savings report.
+type savingsRow struct {
 	Filename string
-	Success  bool
-	Error    error
+	Stats    ImageStats
+}
+
+// printSavingsReport prints a per-file and grand-total size savings table for -dry-run mode.
+func printSavingsReport(rows []savingsRow, diffThreshold int) {
+	fmt.Println("\n📊 Savings report (-dry-run):")
+	fmt.Printf("  %-30s %12s %12s %8s  %s\n", "File", "Before", "After", "Saved", "Status")
+
+	var totalBefore, totalBytes, replaced int
+	for _, row := range rows {
+		pct := reductionPercent(row.Stats.OriginalBytes, row.Stats.FinalBytes)
+		status := "skipped"
+		if row.Stats.Written {
+			status = "replaced"
+			replaced++
+		}
+		fmt.Printf("  %-30s %9d B %9d B %7.1f%%  %s\n",
+			row.Filename, row.Stats.OriginalBytes, row.Stats.FinalBytes, pct, status)
+
+		totalBefore += row.Stats.OriginalBytes
+		totalBytes += row.Stats.FinalBytes
+	}
+
+	fmt.Printf("  %-30s %9d B %9d B %7.1f%%\n",
+		"TOTAL", totalBefore, totalBytes, reductionPercent(totalBefore, totalBytes))
+	fmt.Printf("  %d file(s) replaced, %d skipped (below %d%% threshold)\n",
+		replaced, len(rows)-replaced, diffThreshold)
+}
+
+// rateLimiter wraps a token-bucket limiter and adjusts itself based on the
+// X-RateLimit-Remaining / X-RateLimit-Reset headers returned by the API, so
+// a worker pool backs off before remove.bg starts rejecting requests.
+type rateLimiter struct {
+	mu           sync.Mutex
+	limiter      *rate.Limiter
+	blockedUntil time.Time // zero unless the API reported quota exhaustion
+}
+
+// newRateLimiter builds a rateLimiter allowing ratePerMinute requests/minute.
+// A ratePerMinute of 0 means unlimited (nil limiter).
+func newRateLimiter(ratePerMinute, burst int) *rateLimiter {
+	if ratePerMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(float64(ratePerMinute)/60.0), burst),
+	}
+}
+
+// wait blocks until the limiter permits another request.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	if rl == nil {
+		return nil
+	}
+	rl.mu.Lock()
+	limiter := rl.limiter
+	blockedUntil := rl.blockedUntil
+	rl.mu.Unlock()
+
+	if !blockedUntil.IsZero() {
+		if d := time.Until(blockedUntil); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return limiter.Wait(ctx)
+}
+
+// adjust reads the rate-limit headers from an API response and tightens the
+// limiter if remove.bg reports we're close to exhausting our quota.
+func (rl *rateLimiter) adjust(resp *http.Response) {
+	if rl == nil || resp == nil {
+		return
+	}
+
+	remainingStr := resp.Header.Get("X-RateLimit-Remaining")
+	resetStr := resp.Header.Get("X-RateLimit-Reset")
+	if remainingStr == "" || resetStr == "" {
+		return
+	}
+
+	remaining, err := strconv.Atoi(remainingStr)
+	if err != nil {
+		return
+	}
+	resetSeconds, err := strconv.Atoi(resetStr)
+	if err != nil || resetSeconds <= 0 {
+		return
+	}
+
+	// Spread the remaining requests evenly over the time until reset so we
+	// don't burn through the quota and get a 429 just before it refreshes.
+	safeRate := rate.Limit(float64(remaining) / float64(resetSeconds))
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if remaining <= 0 {
+		// Block every wait() call until the quota resets, instead of touching
+		// the limiter's rate: SetLimitAt's time argument only affects how many
+		// tokens have accrued by then, it does not defer the new limit, so
+		// composing it with SetLimit(0) here made the limiter unlimited
+		// immediately rather than backing off.
+		rl.blockedUntil = time.Now().Add(time.Duration(resetSeconds) * time.Second)
+		return
+	}
+	rl.blockedUntil = time.Time{}
+	if safeRate < rl.limiter.Limit() {
+		rl.limiter.SetLimit(safeRate)
+	}
 }
 
 func main() {
@@ -52,11 +219,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Check for API key
-	config.ApiKey = os.Getenv("REMOVE_BG_API_KEY")
-	if config.ApiKey == "" {
-		fmt.Println("❌ Error: REMOVE_BG_API_KEY environment variable is not set")
-		os.Exit(1)
+	// Check for API key, unless running entirely offline or against another backend
+	if !config.Local && (config.Backend == "" || config.Backend == "remove_bg") {
+		config.ApiKey = os.Getenv("REMOVE_BG_API_KEY")
+		if config.ApiKey == "" {
+			fmt.Println("❌ Error: REMOVE_BG_API_KEY environment variable is not set")
+			os.Exit(1)
+		}
 	}
 
 	// Validate input path
@@ -82,21 +251,29 @@ func main() {
 		if outputPath == "" {
 			outputPath = generateOutputPath(config.InputPath, config.Format)
 		}
-		err := processImage(config.InputPath, outputPath, config)
+		stats, err := processImage(config.InputPath, outputPath, config)
 		if err != nil {
 			fmt.Printf("❌ Error processing %s: %s\n", config.InputPath, err)
 			os.Exit(1)
 		}
-		fmt.Printf("✅ Successfully processed: %s -> %s\n", config.InputPath, outputPath)
+
+		if config.DryRun {
+			printSavingsReport([]savingsRow{{Filename: filepath.Base(config.InputPath), Stats: stats}}, config.DiffThreshold)
+		} else {
+			fmt.Printf("✅ Successfully processed: %s -> %s\n", config.InputPath, outputPath)
+		}
 	}
 }
 
 // Parse command-line arguments manually
 func parseArgs(args []string) (Config, error) {
 	config := Config{
-		Format:   "png",
-		Compress: false,
-		Quality:  90,
+		Format:        "png",
+		Compress:      false,
+		Quality:       90,
+		Jobs:          defaultJobs,
+		DiffThreshold: defaultDiffThreshold,
+		WebpQuality:   defaultWebpQuality,
 	}
 
 	var nonFlagArgs []string
@@ -148,6 +325,196 @@ func parseArgs(args []string) (Config, error) {
 				continue
 			}
 
+			// Worker pool size for directory processing
+			if arg == "-j" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -j flag")
+				}
+				jobs, err := strconv.Atoi(args[i+1])
+				if err != nil || jobs < 1 {
+					return config, fmt.Errorf("invalid value for -j flag: %s", args[i+1])
+				}
+				config.Jobs = jobs
+				i++
+				continue
+			}
+
+			// Rate limit (requests/minute) for the remove.bg API
+			if arg == "-rate" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -rate flag")
+				}
+				rateLimit, err := strconv.Atoi(args[i+1])
+				if err != nil || rateLimit < 1 {
+					return config, fmt.Errorf("invalid value for -rate flag: %s", args[i+1])
+				}
+				config.RateLimit = rateLimit
+				i++
+				continue
+			}
+
+			// Dry-run mode
+			if arg == "-dry-run" {
+				config.DryRun = true
+				continue
+			}
+
+			// Diff threshold for dry-run mode
+			if arg == "-diff" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -diff flag")
+				}
+				diff, err := strconv.Atoi(args[i+1])
+				if err != nil || diff < 0 || diff > 100 {
+					return config, fmt.Errorf("invalid value for -diff flag: %s", args[i+1])
+				}
+				config.DiffThreshold = diff
+				i++
+				continue
+			}
+
+			// Local mode: skip the remove.bg call, input is already a cutout
+			if arg == "-local" {
+				config.Local = true
+				continue
+			}
+
+			// Max output width
+			if arg == "-maxwidth" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -maxwidth flag")
+				}
+				maxWidth, err := strconv.Atoi(args[i+1])
+				if err != nil || maxWidth < 1 {
+					return config, fmt.Errorf("invalid value for -maxwidth flag: %s", args[i+1])
+				}
+				config.MaxWidth = maxWidth
+				i++
+				continue
+			}
+
+			// Max output height
+			if arg == "-maxheight" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -maxheight flag")
+				}
+				maxHeight, err := strconv.Atoi(args[i+1])
+				if err != nil || maxHeight < 1 {
+					return config, fmt.Errorf("invalid value for -maxheight flag: %s", args[i+1])
+				}
+				config.MaxHeight = maxHeight
+				i++
+				continue
+			}
+
+			// Skip resizing outputs already smaller than this many KB
+			if arg == "-atleast" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -atleast flag")
+				}
+				atLeastKB, err := strconv.Atoi(args[i+1])
+				if err != nil || atLeastKB < 1 {
+					return config, fmt.Errorf("invalid value for -atleast flag: %s", args[i+1])
+				}
+				config.AtLeastKB = atLeastKB
+				i++
+				continue
+			}
+
+			// Sidecar WebP generation alongside the primary output
+			if arg == "-also-webp" {
+				config.AlsoWebp = true
+				continue
+			}
+
+			// Quality for the -also-webp sidecar
+			if arg == "-webp-quality" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -webp-quality flag")
+				}
+				quality, err := strconv.Atoi(args[i+1])
+				if err != nil || quality < 1 || quality > 100 {
+					return config, fmt.Errorf("invalid value for -webp-quality flag: %s", args[i+1])
+				}
+				config.WebpQuality = quality
+				i++
+				continue
+			}
+
+			// Background-removal backend selection
+			if arg == "-backend" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -backend flag")
+				}
+				backend := args[i+1]
+				if backend != "remove_bg" && backend != "local" && backend != "http" {
+					return config, fmt.Errorf("invalid value for -backend flag: %s (want remove_bg, local, or http)", backend)
+				}
+				config.Backend = backend
+				i++
+				continue
+			}
+
+			// Binary path for -backend local
+			if arg == "-backend-bin" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -backend-bin flag")
+				}
+				config.BackendBin = args[i+1]
+				i++
+				continue
+			}
+
+			// Endpoint for -backend http
+			if arg == "-backend-url" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -backend-url flag")
+				}
+				config.BackendURL = args[i+1]
+				i++
+				continue
+			}
+
+			// Optional "Name: value" header for -backend http
+			if arg == "-backend-header" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -backend-header flag")
+				}
+				config.BackendHeader = args[i+1]
+				i++
+				continue
+			}
+
+			// Multipart field name for -backend http (default "file")
+			if arg == "-backend-field" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -backend-field flag")
+				}
+				config.BackendField = args[i+1]
+				i++
+				continue
+			}
+
+			// Force reprocessing, ignoring the resume manifest
+			if arg == "-force" {
+				config.Force = true
+				continue
+			}
+
+			// Retries with exponential backoff on transient 429/5xx errors
+			if arg == "-retries" {
+				if i+1 >= len(args) {
+					return config, fmt.Errorf("missing value for -retries flag")
+				}
+				retries, err := strconv.Atoi(args[i+1])
+				if err != nil || retries < 0 {
+					return config, fmt.Errorf("invalid value for -retries flag: %s", args[i+1])
+				}
+				config.Retries = retries
+				i++
+				continue
+			}
+
 			// Handle combined compression
 			if strings.HasPrefix(arg, "-c=") {
 				config.Compress = true
@@ -194,6 +561,41 @@ func printUsage() {
 	fmt.Println("  -c [quality]")
 	fmt.Println("        Compress output image. Optionally specify quality (1-100)")
 	fmt.Println("        Examples: -c (uses default quality 90), -c=75 (sets quality to 75)")
+	fmt.Println("  -j <n>")
+	fmt.Printf("        Number of images to process concurrently when processing a directory (default %d)\n", defaultJobs)
+	fmt.Println("  -rate <n>")
+	fmt.Println("        Max remove.bg requests per minute for directory processing (default: unlimited);")
+	fmt.Println("        when set, the rate also tightens from the API's X-RateLimit headers")
+	fmt.Println("  -dry-run")
+	fmt.Println("        Process images but only keep outputs that meet the -diff threshold; prints a savings report")
+	fmt.Println("  -diff <percent>")
+	fmt.Printf("        Minimum size reduction required to keep a -dry-run output (default %d)\n", defaultDiffThreshold)
+	fmt.Println("  -local")
+	fmt.Println("        Skip the remove.bg call; input is already a cutout PNG/WebP, only re-runs postprocess/encode")
+	fmt.Println("  -maxwidth <n>")
+	fmt.Println("        Downscale outputs wider than n pixels, preserving aspect ratio")
+	fmt.Println("  -maxheight <n>")
+	fmt.Println("        Downscale outputs taller than n pixels, preserving aspect ratio")
+	fmt.Println("  -atleast <KB>")
+	fmt.Println("        Skip resizing outputs already smaller than this many KB")
+	fmt.Println("  -also-webp")
+	fmt.Println("        Also write a <name>-rm.webp sidecar next to the primary output")
+	fmt.Println("  -webp-quality <n>")
+	fmt.Printf("        Quality used for the -also-webp sidecar (default %d)\n", defaultWebpQuality)
+	fmt.Println("  -backend <remove_bg|local|http>")
+	fmt.Println("        Background-removal backend to use (default \"remove_bg\")")
+	fmt.Println("  -backend-bin <path>")
+	fmt.Println("        Binary to shell out to for -backend local (e.g. rembg)")
+	fmt.Println("  -backend-url <url>")
+	fmt.Println("        Endpoint to post to for -backend http")
+	fmt.Println("  -backend-header <\"Name: value\">")
+	fmt.Println("        Optional header to send with -backend http")
+	fmt.Println("  -backend-field <name>")
+	fmt.Println("        Multipart field name for -backend http (default \"file\")")
+	fmt.Println("  -force")
+	fmt.Println("        Ignore the resume manifest (.rmbg-state.json) and reprocess every file in a directory")
+	fmt.Println("  -retries <n>")
+	fmt.Println("        Retries with exponential backoff on transient 429/5xx errors (default 0)")
 	fmt.Println("  -h    Display help information")
 	fmt.Println("Examples:")
 	fmt.Printf("  %s image.jpg                     # Process a single image\n", programName)
@@ -206,6 +608,8 @@ func printUsage() {
 	fmt.Println("Notes:")
 	fmt.Println("  - The REMOVE_BG_API_KEY environment variable must be set")
 	fmt.Println("  - Directory processing creates an output directory with suffix \"-rm\"")
+	fmt.Println("  - Directory processing writes a .rmbg-state.json resume manifest; interrupted runs")
+	fmt.Println("    skip already-succeeded files and retry only failed ones, unless -force is set")
 	fmt.Println("  - Supports JPEG, PNG, and WebP input formats")
 }
 
@@ -260,104 +664,56 @@ func optimizeImage(data []byte, config Config) ([]byte, error) {
 	return image.Process(options)
 }
 
-// Process a single image
-func processImage(inputPath, outputPath string, config Config) error {
-	imageData, err := os.ReadFile(inputPath)
-	if err != nil {
-		return fmt.Errorf("failed to read image: %w", err)
-	}
-
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
-
-	part, err := writer.CreateFormFile("image_file", filepath.Base(inputPath))
-	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
-	}
-	_, err = io.Copy(part, bytes.NewReader(imageData))
-	if err != nil {
-		return fmt.Errorf("failed to copy image data: %w", err)
-	}
-
-	err = writer.WriteField("size", "full")
-	if err != nil {
-		return fmt.Errorf("failed to write field 'size': %w", err)
+// resizeImage downscales data to fit within config.MaxWidth/config.MaxHeight
+// while preserving aspect ratio. Images already smaller than both bounds, or
+// already under config.AtLeastKB, are returned unchanged.
+func resizeImage(data []byte, config Config) ([]byte, error) {
+	if config.MaxWidth <= 0 && config.MaxHeight <= 0 {
+		return data, nil
 	}
-
-	if config.Format == "webp" {
-		err = writer.WriteField("format", "webp")
-		if err != nil {
-			return fmt.Errorf("failed to write field 'format': %w", err)
-		}
+	if config.AtLeastKB > 0 && len(data) < config.AtLeastKB*1024 {
+		return data, nil
 	}
 
-	writer.Close()
-
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", apiURL, &requestBody)
+	image := bimg.NewImage(data)
+	size, err := image.Size()
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return data, err
 	}
 
-	req.Header.Set("X-Api-Key", config.ApiKey)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+	width, height := size.Width, size.Height
+	fitsWidth := config.MaxWidth <= 0 || width <= config.MaxWidth
+	fitsHeight := config.MaxHeight <= 0 || height <= config.MaxHeight
+	if fitsWidth && fitsHeight {
+		return data, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s - %s", resp.Status, string(bodyBytes))
+	targetWidth, targetHeight := width, height
+	if config.MaxWidth > 0 && targetWidth > config.MaxWidth {
+		targetHeight = targetHeight * config.MaxWidth / targetWidth
+		targetWidth = config.MaxWidth
 	}
-
-	outputData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+	if config.MaxHeight > 0 && targetHeight > config.MaxHeight {
+		targetWidth = targetWidth * config.MaxHeight / targetHeight
+		targetHeight = config.MaxHeight
 	}
 
-	if config.Compress {
-		fmt.Printf("🗜️ Compressing image with quality %d...\n", config.Quality)
-		originalSize := len(outputData)
-
-		optimizedData, err := optimizeImage(outputData, config)
-		if err != nil {
-			fmt.Printf("⚠️ Warning: Failed to compress image: %s. Using original output.\n", err)
-			// If optimization fails, use the original data
-			optimizedData = outputData
-		} else {
-			optimizedSize := len(optimizedData)
+	return image.Resize(targetWidth, targetHeight)
+}
 
-			// Only use the optimized data if it actually reduced the size
-			if optimizedSize < originalSize {
-				reduction := float64(originalSize-optimizedSize) / float64(originalSize) * 100
-				fmt.Printf("📊 Reduced file size by %.1f%% (from %d KB to %d KB) with quality %d\n",
-					reduction, originalSize/1024, optimizedSize/1024, config.Quality)
+// Process a single image by running it through the fetch -> cutout ->
+// postprocess -> encode -> write pipeline (see pipeline.go). In -local mode
+// the cutout stage is skipped, so no remove.bg call is made.
+func processImage(inputPath, outputPath string, config Config) (ImageStats, error) {
+	ctx := &pipelineContext{InputPath: inputPath, OutputPath: outputPath, Config: config}
 
-				outputData = optimizedData
-			} else {
-				fmt.Printf("ℹ️ Compression did not reduce file size. Using original output.\n")
-			}
-		}
-	} else if config.Format == "webp" && !strings.HasSuffix(strings.ToLower(outputPath), ".webp") {
-		image := bimg.NewImage(outputData)
-		webpData, err := image.Convert(bimg.WEBP)
-		if err != nil {
-			fmt.Printf("⚠️ Warning: Failed to convert to WebP: %s\n", err)
-		} else {
-			outputData = webpData
+	for _, stage := range buildPipeline(config) {
+		if err := stage.Run(ctx); err != nil {
+			return ctx.Stats, fmt.Errorf("%s: %w", stage.Name(), err)
 		}
 	}
 
-	err = os.WriteFile(outputPath, outputData, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
-	}
-
-	return nil
+	return ctx.Stats, nil
 }
 
 // Process an entire directory of images
@@ -398,32 +754,123 @@ func processDirectory(config Config) {
 		os.Exit(0)
 	}
 
-	fmt.Printf("Found %d images to process...\n", len(imagePaths))
+	manifestFile := filepath.Join(outputDir, manifestFileName)
+	manifest, err := loadManifest(manifestFile)
+	if err != nil {
+		fmt.Printf("⚠️ Warning: Failed to load resume manifest %s: %s. Starting fresh.\n", manifestFile, err)
+		manifest = &Manifest{Entries: map[string]ManifestEntry{}}
+	}
+
+	if !config.Force {
+		var remaining []string
+		for _, imagePath := range imagePaths {
+			hash, err := hashFile(imagePath)
+			if err == nil && manifest.isDone(filepath.Base(imagePath), hash) {
+				continue
+			}
+			remaining = append(remaining, imagePath)
+		}
+		if skipped := len(imagePaths) - len(remaining); skipped > 0 {
+			fmt.Printf("⏭️  Skipping %d image(s) already processed by a previous run (use -force to redo)\n", skipped)
+		}
+		imagePaths = remaining
+	}
+
+	if len(imagePaths) == 0 {
+		fmt.Println("✨ Nothing to do, every image was already processed (use -force to redo)")
+		os.Exit(0)
+	}
+
+	jobs := config.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	fmt.Printf("Found %d images to process (%d workers)...\n", len(imagePaths), jobs)
+
+	config.limiter = newRateLimiter(config.RateLimit, jobs)
+
+	pathCh := make(chan string)
+	resultCh := make(chan ProcessResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for imagePath := range pathCh {
+				baseName := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+				outputPath := filepath.Join(outputDir, baseName+"-rm."+config.Format)
+
+				hash, hashErr := hashFile(imagePath)
+				stats, err := processImage(imagePath, outputPath, config)
+				resultCh <- ProcessResult{
+					Filename:   filepath.Base(imagePath),
+					Success:    err == nil,
+					Error:      err,
+					Stats:      stats,
+					Hash:       hash,
+					HashError:  hashErr,
+					OutputPath: outputPath,
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, imagePath := range imagePaths {
+			pathCh <- imagePath
+		}
+		close(pathCh)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
 
-	// Process images sequentially
+	// Aggregate results as they arrive and render a live progress line
+	start := time.Now()
 	var failedImages []string
 	var successCount int
+	var savings []savingsRow
+	total := len(imagePaths)
 
-	for i, imagePath := range imagePaths {
-		baseName := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
-		outputPath := filepath.Join(outputDir, baseName+"-rm."+config.Format)
-
-		fmt.Printf("\n🔄 Processing image %d/%d: %s\n", i+1, len(imagePaths), filepath.Base(imagePath))
+	for processed := 1; processed <= total; processed++ {
+		result := <-resultCh
+		if result.Success {
+			successCount++
+			savings = append(savings, savingsRow{Filename: result.Filename, Stats: result.Stats})
+		} else {
+			failedImages = append(failedImages, result.Filename)
+		}
 
-		err := processImage(imagePath, outputPath, config)
-		if err != nil {
-			fmt.Printf("❌ Error processing %s: %s\n", filepath.Base(imagePath), err)
-			failedImages = append(failedImages, filepath.Base(imagePath))
+		if result.HashError != nil {
+			// Couldn't compute a content hash to key the resume manifest on;
+			// leave this file's prior entry (if any) untouched rather than
+			// recording a bogus one, so a successful processImage never gets
+			// misfiled as "failed" and reprocessed for nothing.
+			consolePrintf("\n⚠️ Warning: Failed to hash %s for resume manifest: %s\n", result.Filename, result.HashError)
 		} else {
-			fmt.Printf("✅ Successfully processed: %s (%d/%d)\n", filepath.Base(imagePath), i+1, len(imagePaths))
-			successCount++
+			manifest.record(result.Filename, result)
+			if err := manifest.save(manifestFile); err != nil {
+				consolePrintf("\n⚠️ Warning: Failed to save resume manifest: %s\n", err)
+			}
 		}
 
-		// Add a small delay between API calls to avoid rate limiting
-		if i < len(imagePaths)-1 {
-			time.Sleep(500 * time.Millisecond)
+		elapsed := time.Since(start)
+		avgPerImage := elapsed / time.Duration(processed)
+		eta := avgPerImage * time.Duration(total-processed)
+
+		status := "✅"
+		if !result.Success {
+			status = "❌"
 		}
+		consolePrintf("\r%s %d/%d processed, %d failed, ETA %s      ",
+			status, processed, total, len(failedImages), eta.Round(time.Second))
 	}
+	consoleMu.Lock()
+	fmt.Println()
+	consoleMu.Unlock()
 
 	// Print summary
 	fmt.Printf("\n✨ Summary: %d/%d images processed successfully\n", successCount, len(imagePaths))
@@ -434,4 +881,8 @@ func processDirectory(config Config) {
 		}
 	}
 	fmt.Printf("📁 Output directory: %s\n", outputDir)
+
+	if config.DryRun {
+		printSavingsReport(savings, config.DiffThreshold)
+	}
 }
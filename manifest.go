@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// manifestFileName is the resume manifest written into each output
+// directory by processDirectory.
+const manifestFileName = ".rmbg-state.json"
+
+// ManifestEntry records what happened the last time a given input file was
+// processed, so a re-invocation of processDirectory can skip it.
+type ManifestEntry struct {
+	Hash       string `json:"hash"`
+	RequestID  string `json:"request_id,omitempty"`
+	Timestamp  string `json:"timestamp"`
+	OutputPath string `json:"output_path"`
+	Status     string `json:"status"` // "success" or "failed"
+}
+
+// Manifest is the on-disk resume state for a directory batch, keyed by the
+// input file's base name.
+type Manifest struct {
+	Entries map[string]ManifestEntry `json:"entries"`
+}
+
+// loadManifest reads the manifest at path, returning an empty one if it
+// doesn't exist yet.
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Entries: map[string]ManifestEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]ManifestEntry{}
+	}
+	return &manifest, nil
+}
+
+// save writes the manifest to path as indented JSON. It writes to a
+// sibling .tmp file first and renames it into place, so a crash or kill
+// mid-write (the exact interruption this feature exists for) can't leave a
+// truncated manifest that forces a full, credit-burning reprocess.
+func (m *Manifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// isDone reports whether the input file stored under key was successfully processed in a prior run and
+// hasn't changed since (its content hash still matches).
+func (m *Manifest) isDone(key, hash string) bool {
+	entry, ok := m.Entries[key]
+	return ok && entry.Status == "success" && entry.Hash == hash
+}
+
+// record stores the outcome of processing an input file under key. Status is
+// keyed off Stats.Written rather than Success: a -dry-run file that was
+// deliberately skipped for missing the -diff threshold has Success == true
+// (writeStage returned no error) but Written == false, and must not be
+// marked "success" here or a later, non-dry-run invocation would skip it via
+// isDone and silently produce no output for it.
+func (m *Manifest) record(key string, result ProcessResult) {
+	status := "failed"
+	if result.Success && result.Stats.Written {
+		status = "success"
+	}
+	m.Entries[key] = ManifestEntry{
+		Hash:       result.Hash,
+		RequestID:  result.Stats.RequestID,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		OutputPath: result.OutputPath,
+		Status:     status,
+	}
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path, used to
+// detect whether an input changed since it was last successfully processed.
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/h2non/bimg"
+)
+
+// consoleMu serializes stdout writes that can happen concurrently with
+// processDirectory's `\r`-rewritten live progress line, so per-image
+// diagnostics from worker goroutines don't garble it.
+var consoleMu sync.Mutex
+
+// consolePrintf is fmt.Printf guarded by consoleMu.
+func consolePrintf(format string, args ...interface{}) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	fmt.Printf(format, args...)
+}
+
+// pipelineContext carries an image through the fetch -> cutout -> postprocess
+// -> encode -> write stages. Stages read/write Data and Stats in place.
+type pipelineContext struct {
+	InputPath   string
+	OutputPath  string
+	Config      Config
+	Data        []byte
+	Stats       ImageStats
+	SidecarWebp []byte // -also-webp output, derived from the same cutout as Data
+}
+
+// Stage is one step of the image processing pipeline. Future stages
+// (upscaling, alpha matte refinement, shadow generation, ...) plug in by
+// implementing this interface and getting added to buildPipeline.
+type Stage interface {
+	Name() string
+	Run(ctx *pipelineContext) error
+}
+
+// stageFunc adapts a plain function to the Stage interface, the same way
+// http.HandlerFunc adapts a function to http.Handler.
+type stageFunc struct {
+	name string
+	fn   func(ctx *pipelineContext) error
+}
+
+func (s stageFunc) Name() string                   { return s.name }
+func (s stageFunc) Run(ctx *pipelineContext) error { return s.fn(ctx) }
+
+// buildPipeline assembles the stages to run for the given config. In -local
+// mode the cutout stage (the remove.bg round-trip) is skipped entirely, and
+// fetch instead reads an already-processed cutout straight off disk.
+func buildPipeline(config Config) []Stage {
+	stages := []Stage{fetchStage}
+	if !config.Local {
+		stages = append(stages, cutoutStage)
+	}
+	stages = append(stages, postprocessStage, encodeStage, writeStage)
+	return stages
+}
+
+// fetchStage reads the input file from disk. In -local mode the input is
+// already a cutout PNG/WebP, so its bytes become the pipeline's working data
+// directly and the original size is recorded for the savings report.
+var fetchStage = stageFunc{name: "fetch", fn: func(ctx *pipelineContext) error {
+	data, err := os.ReadFile(ctx.InputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	ctx.Data = data
+	ctx.Stats.OriginalBytes = len(data)
+	return nil
+}}
+
+// cutoutStage runs the fetched image through the configured Backend (see
+// backend.go) and replaces the working data with the returned cutout.
+var cutoutStage = stageFunc{name: "cutout", fn: func(ctx *pipelineContext) error {
+	backend, err := selectBackend(ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	outputData, err := backend.RemoveBackground(context.Background(), ctx.Data)
+	if err != nil {
+		return err
+	}
+
+	if rb, ok := backend.(*RemoveBgBackend); ok {
+		ctx.Stats.RequestID = rb.LastRequestID
+	}
+
+	ctx.Data = outputData
+	ctx.Stats.OriginalBytes = len(outputData)
+	return nil
+}}
+
+// postprocessStage is the extension point for local, non-API transforms
+// (resize, upscaling, alpha matte refinement, shadow generation, ...) that
+// run on the cutout before it is encoded. It currently applies the
+// -maxwidth/-maxheight/-atleast resize constraints, if any were set.
+var postprocessStage = stageFunc{name: "postprocess", fn: func(ctx *pipelineContext) error {
+	config := ctx.Config
+
+	resized, err := resizeImage(ctx.Data, config)
+	if err != nil {
+		consolePrintf("⚠️ Warning: Failed to resize image: %s. Using original size.\n", err)
+		return nil
+	}
+	ctx.Data = resized
+	return nil
+}}
+
+// encodeStage applies -c compression or a plain WebP conversion to the
+// working data, the same way processImage used to inline.
+var encodeStage = stageFunc{name: "encode", fn: func(ctx *pipelineContext) error {
+	config := ctx.Config
+	outputData := ctx.Data
+
+	if config.AlsoWebp && config.Format != "webp" {
+		webpData, err := bimg.NewImage(outputData).Process(bimg.Options{
+			Type:          bimg.WEBP,
+			Quality:       config.WebpQuality,
+			StripMetadata: true,
+		})
+		if err != nil {
+			consolePrintf("⚠️ Warning: Failed to generate -also-webp sidecar: %s\n", err)
+		} else {
+			ctx.SidecarWebp = webpData
+		}
+	}
+
+	if config.Compress {
+		consolePrintf("🗜️ Compressing image with quality %d...\n", config.Quality)
+		originalSize := len(outputData)
+
+		optimizedData, err := optimizeImage(outputData, config)
+		if err != nil {
+			consolePrintf("⚠️ Warning: Failed to compress image: %s. Using original output.\n", err)
+			optimizedData = outputData
+		} else {
+			optimizedSize := len(optimizedData)
+
+			if optimizedSize < originalSize {
+				reduction := float64(originalSize-optimizedSize) / float64(originalSize) * 100
+				consolePrintf("📊 Reduced file size by %.1f%% (from %d KB to %d KB) with quality %d\n",
+					reduction, originalSize/1024, optimizedSize/1024, config.Quality)
+				outputData = optimizedData
+			} else {
+				consolePrintf("ℹ️ Compression did not reduce file size. Using original output.\n")
+			}
+		}
+	} else if config.Format == "webp" && !strings.HasSuffix(strings.ToLower(ctx.OutputPath), ".webp") {
+		image := bimg.NewImage(outputData)
+		webpData, err := image.Convert(bimg.WEBP)
+		if err != nil {
+			consolePrintf("⚠️ Warning: Failed to convert to WebP: %s\n", err)
+		} else {
+			outputData = webpData
+		}
+	}
+
+	ctx.Data = outputData
+	ctx.Stats.FinalBytes = len(outputData)
+	return nil
+}}
+
+// writeStage writes the final data to disk, unless -dry-run is set and the
+// reduction didn't meet the -diff threshold.
+var writeStage = stageFunc{name: "write", fn: func(ctx *pipelineContext) error {
+	config := ctx.Config
+
+	if config.DryRun && reductionPercent(ctx.Stats.OriginalBytes, ctx.Stats.FinalBytes) < float64(config.DiffThreshold) {
+		// Output didn't shrink enough to be worth keeping; report it as skipped.
+		return nil
+	}
+
+	if err := os.WriteFile(ctx.OutputPath, ctx.Data, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	ctx.Stats.Written = true
+
+	if ctx.SidecarWebp != nil {
+		sidecarPath := sidecarWebpPath(ctx.OutputPath)
+		if err := os.WriteFile(sidecarPath, ctx.SidecarWebp, 0644); err != nil {
+			consolePrintf("⚠️ Warning: Failed to write -also-webp sidecar %s: %s\n", sidecarPath, err)
+		} else {
+			consolePrintf("🖼️ Also wrote WebP sidecar: %s\n", sidecarPath)
+		}
+	}
+
+	return nil
+}}
+
+// sidecarWebpPath derives the -also-webp sidecar path from the primary
+// output path by swapping its extension for .webp.
+func sidecarWebpPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + ".webp"
+}